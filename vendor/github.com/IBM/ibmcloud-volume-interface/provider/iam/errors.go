@@ -0,0 +1,123 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iam
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrIAMUnavailable indicates IAM could not be reached (network failure,
+// timeout), or returned a non-200, non-429 response carrying no IAM error
+// message. A response that does carry an error message but fits none of
+// the more specific error types below is reported as a generic
+// ErrorFailedTokenExchange error, not as ErrIAMUnavailable.
+type ErrIAMUnavailable struct {
+	Cause error
+}
+
+func (e *ErrIAMUnavailable) Error() string { return fmt.Sprintf("IAM is unavailable: %v", e.Cause) }
+func (e *ErrIAMUnavailable) Unwrap() error { return e.Cause }
+func (e *ErrIAMUnavailable) Is(target error) bool {
+	_, ok := target.(*ErrIAMUnavailable)
+	return ok
+}
+
+// ErrInvalidAPIKey indicates IAM rejected the supplied API key or other
+// grant credential.
+type ErrInvalidAPIKey struct {
+	Cause error
+}
+
+func (e *ErrInvalidAPIKey) Error() string { return fmt.Sprintf("invalid IAM credential: %v", e.Cause) }
+func (e *ErrInvalidAPIKey) Unwrap() error { return e.Cause }
+func (e *ErrInvalidAPIKey) Is(target error) bool {
+	_, ok := target.(*ErrInvalidAPIKey)
+	return ok
+}
+
+// ErrAccountLocked indicates the infrastructure account backing the
+// credential is temporarily locked.
+type ErrAccountLocked struct {
+	Cause error
+}
+
+func (e *ErrAccountLocked) Error() string {
+	return fmt.Sprintf("infrastructure account is temporarily locked: %v", e.Cause)
+}
+func (e *ErrAccountLocked) Unwrap() error { return e.Cause }
+func (e *ErrAccountLocked) Is(target error) bool {
+	_, ok := target.(*ErrAccountLocked)
+	return ok
+}
+
+// ErrRateLimited indicates IAM returned a 429. RetryAfter, when non-zero,
+// is the backoff IAM asked for via the Retry-After header.
+type ErrRateLimited struct {
+	Cause      error
+	RetryAfter time.Duration
+}
+
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("IAM rate limited the request (retry after %s): %v", e.RetryAfter, e.Cause)
+}
+func (e *ErrRateLimited) Unwrap() error { return e.Cause }
+func (e *ErrRateLimited) Is(target error) bool {
+	_, ok := target.(*ErrRateLimited)
+	return ok
+}
+
+// ErrTokenExpired indicates a previously issued token is no longer
+// accepted by IAM or by the downstream call that used it.
+type ErrTokenExpired struct {
+	Cause error
+}
+
+func (e *ErrTokenExpired) Error() string { return fmt.Sprintf("IAM token expired: %v", e.Cause) }
+func (e *ErrTokenExpired) Unwrap() error { return e.Cause }
+func (e *ErrTokenExpired) Is(target error) bool {
+	_, ok := target.(*ErrTokenExpired)
+	return ok
+}
+
+// parseRetryAfter parses the Retry-After header of resp, supporting both
+// the delay-seconds and HTTP-date forms. Returns zero when the header is
+// absent or unparseable.
+func parseRetryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
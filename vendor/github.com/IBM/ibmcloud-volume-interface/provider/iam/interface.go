@@ -0,0 +1,81 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iam
+
+import "go.uber.org/zap"
+
+// TokenExchangeService ...
+type TokenExchangeService interface {
+	ExchangeIAMAPIKeyForAccessToken(iamAPIKey string, logger *zap.Logger) (*AccessToken, error)
+	ExchangeIAMAPIKeyForIMSToken(iamAPIKey string, logger *zap.Logger) (*IMSToken, error)
+	ExchangeAccessTokenForIMSToken(accessToken AccessToken, logger *zap.Logger) (*IMSToken, error)
+	ExchangeRefreshTokenForAccessToken(refreshToken string, logger *zap.Logger) (*AccessToken, error)
+	UpdateAPIKey(apiKey string, logger *zap.Logger) error
+
+	// ExchangeCRTokenForAccessToken exchanges a compute-resource identity
+	// token read from crTokenPath (AuthConfiguration.CRTokenPath when
+	// empty) for an access token scoped to the trusted profile identified
+	// by profileID (AuthConfiguration.ProfileID when empty, and
+	// AuthConfiguration.ProfileName when both are empty). Exchanged tokens are cached
+	// like any other grant type; the token file is only re-read from disk
+	// on a cache miss or skew-window refresh, since kubelet rotates it in
+	// place.
+	ExchangeCRTokenForAccessToken(crTokenPath, profileID string, logger *zap.Logger) (*AccessToken, error)
+
+	// AssumeProfile exchanges accessToken for a new access token scoped to
+	// the trusted profile identified by profileID (AuthConfiguration.ProfileID
+	// when empty).
+	AssumeProfile(accessToken AccessToken, profileID string, logger *zap.Logger) (*AccessToken, error)
+
+	// ExchangeIAMAPIKeyForScopedAccessToken exchanges iamAPIKey for an
+	// access token narrowed to scope, verifying the returned token's
+	// scope/resource claims match what was requested before returning it.
+	ExchangeIAMAPIKeyForScopedAccessToken(iamAPIKey string, scope Scope, logger *zap.Logger) (*AccessToken, error)
+
+	// SetTokenVerifier installs verifier so every access token IAM
+	// returns is verified before being handed back to callers. A nil
+	// verifier (the default) preserves the previous trust-IAM-blindly
+	// behavior.
+	SetTokenVerifier(verifier TokenVerifier)
+
+	// SetRefreshToken seeds the refresh token credential ExchangeRefreshTokenForAccessToken
+	// falls back to when called with an empty string, so operators can
+	// load it once at startup (e.g. from a Kubernetes Secret) rather than
+	// threading it through every call site.
+	SetRefreshToken(refreshToken string)
+
+	// InvalidateCache drops any cached access token stored under key, so
+	// the next exchange for that key round-trips to IAM instead of
+	// returning a stale cache entry.
+	InvalidateCache(key string)
+
+	// Close stops this service from starting any further skew-window
+	// background refreshes; one already in flight is allowed to finish.
+	// Safe to call more than once.
+	Close()
+}
+
+// AccessToken ...
+type AccessToken struct {
+	Token string
+}
+
+// IMSToken ...
+type IMSToken struct {
+	UserID int
+	Token  string
+}
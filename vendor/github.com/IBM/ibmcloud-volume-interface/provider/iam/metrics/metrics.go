@@ -0,0 +1,73 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package metrics exposes the Prometheus metrics emitted by the iam
+// package's token exchange service.
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// TokenExchangeRequestsTotal counts IAM /oidc/token requests, by
+	// grant type and result (success, rate_limited, account_locked,
+	// invalid_api_key, token_expired, iam_unavailable, error).
+	TokenExchangeRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iam_token_exchange_requests_total",
+			Help: "Total number of IAM token exchange requests, by grant type and result.",
+		},
+		[]string{"grant_type", "result"},
+	)
+
+	// TokenExchangeDurationSeconds observes the latency of IAM /oidc/token
+	// requests, by grant type.
+	TokenExchangeDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iam_token_exchange_duration_seconds",
+			Help:    "Latency of IAM token exchange requests, by grant type.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"grant_type"},
+	)
+
+	// TokenCacheHitsTotal counts access token requests served from the
+	// in-memory cache instead of round-tripping to IAM.
+	TokenCacheHitsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "iam_token_cache_hits_total",
+			Help: "Total number of IAM access token requests served from the in-memory cache.",
+		},
+	)
+
+	// TokenRefreshTotal counts access token refreshes, by trigger
+	// (cache_miss, skew_window).
+	TokenRefreshTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iam_token_refresh_total",
+			Help: "Total number of IAM access token refreshes, by trigger.",
+		},
+		[]string{"trigger"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		TokenExchangeRequestsTotal,
+		TokenExchangeDurationSeconds,
+		TokenCacheHitsTotal,
+		TokenRefreshTotal,
+	)
+}
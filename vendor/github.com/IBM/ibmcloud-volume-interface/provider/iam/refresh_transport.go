@@ -0,0 +1,229 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TokenSource supplies the bearer token RefreshingRoundTripper attaches to
+// outgoing requests, and mints a new one when asked to Refresh.
+// RefreshableTokenSource (a long-lived token kept alive via a stored
+// refresh token) and ScopedTokenSource (a narrowly scoped token minted
+// fresh for one CSI operation) both implement it, so a CSI controller
+// method can swap in a ScopedTokenSource for just the RIaaS calls it makes
+// on a volume's behalf instead of using the driver-wide refresh token.
+type TokenSource interface {
+	Token(ctx context.Context) (AccessToken, error)
+	Refresh(ctx context.Context) (AccessToken, error)
+}
+
+// RefreshableTokenSource holds an access token / refresh token pair and
+// exchanges a new access token through a TokenExchangeService on demand.
+type RefreshableTokenSource struct {
+	tes    TokenExchangeService
+	logger *zap.Logger
+
+	mu           sync.Mutex
+	accessToken  AccessToken
+	refreshToken string
+	expiry       time.Time
+}
+
+// NewRefreshableTokenSource ...
+func NewRefreshableTokenSource(tes TokenExchangeService, refreshToken string, logger *zap.Logger) *RefreshableTokenSource {
+	tes.SetRefreshToken(refreshToken)
+
+	return &RefreshableTokenSource{
+		tes:          tes,
+		refreshToken: refreshToken,
+		logger:       logger,
+	}
+}
+
+// Token returns the current access token, exchanging one for the first
+// time if none has been obtained yet.
+func (s *RefreshableTokenSource) Token(ctx context.Context) (AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken.Token != "" {
+		return s.accessToken, nil
+	}
+
+	return s.refreshLocked()
+}
+
+// Refresh forces a new access token to be exchanged using the stored
+// refresh token, e.g. after a downstream call reports the current one is
+// no longer accepted.
+func (s *RefreshableTokenSource) Refresh(ctx context.Context) (AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.refreshLocked()
+}
+
+func (s *RefreshableTokenSource) refreshLocked() (AccessToken, error) {
+	token, err := s.tes.ExchangeRefreshTokenForAccessToken(s.refreshToken, s.logger)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	s.accessToken = *token
+	if expiresAt, err := jwtExpiry(token.Token); err == nil {
+		s.expiry = expiresAt
+	}
+
+	return s.accessToken, nil
+}
+
+// IsAuthError reports whether an HTTP response should be treated as an IAM
+// authentication failure that warrants a token refresh and retry.
+type IsAuthError func(resp *http.Response) bool
+
+// iamAuthErrorBody is the minimal shape of the JSON error body RIaaS
+// returns when a request fails because IAM rejected the bearer token,
+// rather than some other internal error. The exact code/message values
+// should be confirmed against RIaaS's error catalog; treat these as the
+// known set observed so far.
+type iamAuthErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// DefaultIsAuthError treats a 401 response, or a 500 whose JSON body
+// identifies the failure as an IAM authentication error, as an IAM auth
+// failure warranting a token refresh and retry.
+func DefaultIsAuthError(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	if resp.StatusCode != http.StatusInternalServerError {
+		return false
+	}
+	return hasIAMAuthErrorBody(resp)
+}
+
+// hasIAMAuthErrorBody peeks resp.Body for an iamAuthErrorBody identifying
+// an IAM auth failure, restoring the body afterwards so the caller can
+// still read it.
+func hasIAMAuthErrorBody(resp *http.Response) bool {
+	if resp.Body == nil {
+		return false
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	var errBody iamAuthErrorBody
+	if err := json.Unmarshal(body, &errBody); err != nil {
+		return false
+	}
+
+	switch strings.ToLower(errBody.Code) {
+	case "iam_token_invalid", "iam_token_expired", "authentication_failed":
+		return true
+	}
+
+	message := strings.ToLower(errBody.Message)
+	return strings.Contains(message, "iam") && strings.Contains(message, "token")
+}
+
+// RefreshingRoundTripper wraps an http.RoundTripper, replacing the bearer
+// credential from a TokenSource and retrying the request once if the
+// downstream RIaaS/SoftLayer call reports an auth failure.
+type RefreshingRoundTripper struct {
+	Base        http.RoundTripper
+	TokenSource TokenSource
+	IsAuthError IsAuthError
+}
+
+// RoundTrip ...
+func (rt *RefreshingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := rt.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	isAuthError := rt.IsAuthError
+	if isAuthError == nil {
+		isAuthError = DefaultIsAuthError
+	}
+
+	token, err := rt.TokenSource.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	// http.RoundTripper must not modify the request it is given, so the
+	// Authorization header is set on a clone, not req itself; req.Clone
+	// only deep-copies the header (and struct fields), it reuses req.Body
+	// as-is, which is what we want for this first attempt.
+	resp, err := base.RoundTrip(withBearerToken(req.Clone(req.Context()), token))
+	if err != nil || !isAuthError(resp) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	token, err = rt.TokenSource.Refresh(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.Body != nil {
+		// The first RoundTrip already drained and closed req.Body, and
+		// Clone does not repopulate Body from GetBody. Rewind it via
+		// GetBody so the retried request carries the original payload
+		// instead of silently sending an empty one.
+		if req.GetBody == nil {
+			return nil, errors.New("iam: cannot retry request with a body that does not support rewinding (req.GetBody is nil)")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+
+	return base.RoundTrip(withBearerToken(retry, token))
+}
+
+// withBearerToken sets the Authorization header on req and returns it. req
+// must be a clone the caller owns, never the original *http.Request handed
+// to RoundTrip.
+func withBearerToken(req *http.Request, token AccessToken) *http.Request {
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	return req
+}
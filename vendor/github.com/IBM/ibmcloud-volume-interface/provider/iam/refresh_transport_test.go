@@ -0,0 +1,198 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iam
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// stubTokenSource returns a fixed token on Token and a different one on
+// Refresh, so tests can tell the two apart.
+type stubTokenSource struct {
+	tokenCalls   int
+	refreshCalls int
+}
+
+func (s *stubTokenSource) Token(ctx context.Context) (AccessToken, error) {
+	s.tokenCalls++
+	return AccessToken{Token: "initial-token"}, nil
+}
+
+func (s *stubTokenSource) Refresh(ctx context.Context) (AccessToken, error) {
+	s.refreshCalls++
+	return AccessToken{Token: "refreshed-token"}, nil
+}
+
+// recordingRoundTripper records the Authorization header and body of every
+// request it sees, and returns resp on the first call, then a 200 OK.
+type recordingRoundTripper struct {
+	firstResp  *http.Response
+	calls      int
+	authHeader []string
+	bodies     []string
+}
+
+func (rt *recordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.calls++
+	rt.authHeader = append(rt.authHeader, req.Header.Get("Authorization"))
+
+	body := ""
+	if req.Body != nil {
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+	}
+	rt.bodies = append(rt.bodies, body)
+
+	if rt.calls == 1 && rt.firstResp != nil {
+		return rt.firstResp, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+}
+
+func newUnauthorizedResponse() *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusUnauthorized,
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+	}
+}
+
+func TestRefreshingRoundTripperRetriesOn401(t *testing.T) {
+	base := &recordingRoundTripper{firstResp: newUnauthorizedResponse()}
+	tokenSource := &stubTokenSource{}
+	rt := &RefreshingRoundTripper{Base: base, TokenSource: tokenSource}
+
+	req, err := http.NewRequest(http.MethodGet, "https://riaas.example/volumes", nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip returned error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the retried request to succeed, got status %d", resp.StatusCode)
+	}
+	if base.calls != 2 {
+		t.Fatalf("expected exactly one retry (2 round trips), got %d", base.calls)
+	}
+	if base.authHeader[0] != "Bearer initial-token" {
+		t.Fatalf("unexpected first Authorization header: %q", base.authHeader[0])
+	}
+	if base.authHeader[1] != "Bearer refreshed-token" {
+		t.Fatalf("unexpected retried Authorization header: %q", base.authHeader[1])
+	}
+	if tokenSource.refreshCalls != 1 {
+		t.Fatalf("expected Refresh to be called once, got %d", tokenSource.refreshCalls)
+	}
+}
+
+func TestRefreshingRoundTripperRewindsBodyOnRetry(t *testing.T) {
+	base := &recordingRoundTripper{firstResp: newUnauthorizedResponse()}
+	rt := &RefreshingRoundTripper{Base: base, TokenSource: &stubTokenSource{}}
+
+	req, err := http.NewRequest(http.MethodPost, "https://riaas.example/volumes", strings.NewReader("volume-payload"))
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip returned error: %v", err)
+	}
+
+	if len(base.bodies) != 2 {
+		t.Fatalf("expected 2 round trips, got %d", len(base.bodies))
+	}
+	if base.bodies[0] != "volume-payload" {
+		t.Fatalf("expected the first attempt to carry the original body, got %q", base.bodies[0])
+	}
+	if base.bodies[1] != "volume-payload" {
+		t.Fatalf("expected the retried request to carry the rewound body, got %q", base.bodies[1])
+	}
+}
+
+func TestRefreshingRoundTripperRejectsUnrewindableBodyOnRetry(t *testing.T) {
+	base := &recordingRoundTripper{firstResp: newUnauthorizedResponse()}
+	rt := &RefreshingRoundTripper{Base: base, TokenSource: &stubTokenSource{}}
+
+	req, err := http.NewRequest(http.MethodPost, "https://riaas.example/volumes", strings.NewReader("volume-payload"))
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	// Simulate a request body that cannot be rewound (e.g. a raw
+	// io.Reader set directly instead of via NewRequest's strings.Reader/
+	// bytes.Reader/bytes.Buffer special-casing).
+	req.GetBody = nil
+
+	if _, err := rt.RoundTrip(req); err == nil {
+		t.Fatalf("expected RoundTrip to reject a retry whose body cannot be rewound")
+	}
+}
+
+func TestRefreshingRoundTripperDoesNotMutateCallerRequest(t *testing.T) {
+	base := &recordingRoundTripper{}
+	rt := &RefreshingRoundTripper{Base: base, TokenSource: &stubTokenSource{}}
+
+	req, err := http.NewRequest(http.MethodGet, "https://riaas.example/volumes", nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip returned error: %v", err)
+	}
+
+	if req.Header.Get("Authorization") != "" {
+		t.Fatalf("expected RoundTrip to leave the caller's request untouched, got Authorization %q", req.Header.Get("Authorization"))
+	}
+}
+
+func TestDefaultIsAuthErrorTreats500WithIAMBodyAsAuthError(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"code":"iam_token_expired","message":"IAM token expired"}`)),
+	}
+
+	if !DefaultIsAuthError(resp) {
+		t.Fatalf("expected a 500 with an IAM auth error body to be treated as an auth error")
+	}
+
+	// The body must still be readable afterwards.
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body after DefaultIsAuthError: %v", err)
+	}
+	if !strings.Contains(string(body), "iam_token_expired") {
+		t.Fatalf("expected response body to be preserved, got %q", body)
+	}
+}
+
+func TestDefaultIsAuthErrorIgnoresUnrelated500(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"code":"internal_error","message":"something else broke"}`)),
+	}
+
+	if DefaultIsAuthError(resp) {
+		t.Fatalf("expected an unrelated 500 not to be treated as an auth error")
+	}
+}
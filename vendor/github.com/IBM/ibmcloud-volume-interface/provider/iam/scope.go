@@ -0,0 +1,100 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iam
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Scope narrows an access token to a single resource and action set for
+// the lifetime of one CSI operation, so a token that leaks from a node
+// carries only the blast radius of that one volume/action instead of the
+// caller's full IAM API key privileges. A controller operation (e.g.
+// ControllerPublishVolume) obtains a token scoped like this by plugging a
+// ScopedTokenSource built from its volume CRN and actions into a
+// RefreshingRoundTripper for the RIaaS call it makes — see
+// ScopedTokenSource's doc comment for the call-site pattern.
+type Scope struct {
+	// CRN is the Cloud Resource Name of the resource (e.g. a volume or
+	// VPC) the token is allowed to act against.
+	CRN string
+
+	// Actions is the set of IAM actions the token is allowed to perform,
+	// e.g. "is.volume.volume.attach", "is.volume.volume.detach".
+	Actions []string
+
+	// TTL bounds how long the token IAM mints for this scope remains
+	// valid.
+	TTL time.Duration
+}
+
+// scopeRequest is the JSON shape IAM expects in the `scope` form field of
+// an /oidc/token request.
+type scopeRequest struct {
+	Resource   string   `json:"resource"`
+	Actions    []string `json:"actions"`
+	TTLSeconds int64    `json:"ttl_seconds,omitempty"`
+}
+
+// scopeClaims is the subset of a scoped access token's JWT claims that
+// verify() checks against the requested Scope.
+type scopeClaims struct {
+	Scope    []string `json:"scope"`
+	Resource string   `json:"resource"`
+}
+
+// encode renders s as the JSON value IAM expects in the `scope` form
+// field.
+func (s Scope) encode() (string, error) {
+	b, err := json.Marshal(scopeRequest{
+		Resource:   s.CRN,
+		Actions:    s.Actions,
+		TTLSeconds: int64(s.TTL.Seconds()),
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// verify checks that the `resource` claim on rawJWT equals s.CRN and that
+// its `scope` claim grants every action in s.Actions. A token whose scope
+// grants further actions beyond those requested is accepted.
+func (s Scope) verify(rawJWT string) error {
+	var claims scopeClaims
+	if err := decodeJWTPayload(rawJWT, &claims); err != nil {
+		return err
+	}
+
+	if claims.Resource != s.CRN {
+		return fmt.Errorf("token resource %q does not match requested CRN %q", claims.Resource, s.CRN)
+	}
+
+	granted := make(map[string]bool, len(claims.Scope))
+	for _, action := range claims.Scope {
+		granted[action] = true
+	}
+	for _, action := range s.Actions {
+		if !granted[action] {
+			return fmt.Errorf("token scope is missing requested action %q", action)
+		}
+	}
+
+	return nil
+}
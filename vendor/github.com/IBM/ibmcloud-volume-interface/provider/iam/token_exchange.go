@@ -18,24 +18,58 @@
 package iam
 
 import (
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/IBM-Cloud/ibm-cloud-cli-sdk/common/rest"
 	"github.com/IBM/ibmcloud-volume-interface/config"
 	util "github.com/IBM/ibmcloud-volume-interface/lib/utils"
+	"github.com/IBM/ibmcloud-volume-interface/provider/iam/metrics"
 )
 
+// defaultTokenSkew is how long before its expiry a cached access token is
+// proactively refreshed in the background instead of being served as-is.
+const defaultTokenSkew = 5 * time.Minute
+
+// cachedToken ...
+type cachedToken struct {
+	token     AccessToken
+	expiresAt time.Time
+}
+
 // tokenExchangeService ...
 type tokenExchangeService struct {
 	authConfig *AuthConfiguration
 	httpClient *http.Client
+
+	skew time.Duration
+
+	cacheMu sync.Mutex
+	cache   map[string]*cachedToken
+	sf      singleflight.Group
+
+	refreshTokenMu sync.Mutex
+	refreshToken   string
+
+	// verifier, when set, verifies every access token IAM returns before
+	// it is handed back to the caller. A nil verifier preserves the
+	// previous trust-IAM-blindly behavior.
+	verifier TokenVerifier
+
+	stopCh    chan struct{}
+	closeOnce sync.Once
 }
 
 // AuthConfiguration ...
@@ -43,6 +77,18 @@ type AuthConfiguration struct {
 	IamURL          string
 	IamClientID     string
 	IamClientSecret string
+
+	// ProfileID and ProfileName identify the trusted profile to assume or
+	// to bind a compute-resource token exchange to. ProfileID takes
+	// precedence when both are set.
+	ProfileID   string
+	ProfileName string
+
+	// CRTokenPath is the path to the projected compute-resource service
+	// account token file (e.g. /var/run/secrets/tokens/vault-token) used
+	// by ExchangeCRTokenForAccessToken. kubelet rotates this file in
+	// place, so it is re-read on every exchange.
+	CRTokenPath string
 }
 
 // TokenExchangeService ...
@@ -53,6 +99,9 @@ func NewTokenExchangeServiceWithClient(authConfig *AuthConfiguration, httpClient
 	return &tokenExchangeService{
 		authConfig: authConfig,
 		httpClient: httpClient,
+		skew:       defaultTokenSkew,
+		cache:      map[string]*cachedToken{},
+		stopCh:     make(chan struct{}),
 	}, nil
 }
 
@@ -65,6 +114,9 @@ func NewTokenExchangeService(authConfig *AuthConfiguration) (TokenExchangeServic
 	return &tokenExchangeService{
 		authConfig: authConfig,
 		httpClient: httpClient,
+		skew:       defaultTokenSkew,
+		cache:      map[string]*cachedToken{},
+		stopCh:     make(chan struct{}),
 	}, nil
 }
 
@@ -75,30 +127,52 @@ type tokenExchangeRequest struct {
 	client       *rest.Client
 	logger       *zap.Logger
 	errorRetrier *util.ErrorRetrier
+	grantType    string
 }
 
 // tokenExchangeResponse ...
 type tokenExchangeResponse struct {
-	AccessToken string `json:"access_token"`
-	ImsToken    string `json:"ims_token"`
-	ImsUserID   int    `json:"ims_user_id"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	ImsToken     string `json:"ims_token"`
+	ImsUserID    int    `json:"ims_user_id"`
 }
 
-// ExchangeRefreshTokenForAccessToken ...
+// ExchangeRefreshTokenForAccessToken exchanges refreshToken for an access
+// token. When refreshToken is empty, the refresh token last passed to
+// SetRefreshToken is used instead, so operators that seed it once at
+// startup don't need to thread it through every call site.
 func (tes *tokenExchangeService) ExchangeRefreshTokenForAccessToken(refreshToken string, logger *zap.Logger) (*AccessToken, error) {
-	r := tes.newTokenExchangeRequest(logger)
+	if refreshToken == "" {
+		tes.refreshTokenMu.Lock()
+		refreshToken = tes.refreshToken
+		tes.refreshTokenMu.Unlock()
+	}
+	if refreshToken == "" {
+		return nil, errors.New("iam: no refresh token available: call SetRefreshToken or pass one explicitly")
+	}
 
-	r.request.Field("grant_type", "refresh_token")
-	r.request.Field("refresh_token", refreshToken)
+	key := "refresh-token:" + refreshToken
 
-	return r.exchangeForAccessToken()
+	return tes.cachedAccessToken(key, logger, func() (*AccessToken, error) {
+		r := tes.newTokenExchangeRequest(logger)
+
+		r.grantType = "refresh_token"
+		r.request.Field("grant_type", r.grantType)
+		r.request.Field("refresh_token", refreshToken)
+
+		return dropClaims(r.exchangeForAccessToken())
+	})
 }
 
 // ExchangeAccessTokenForIMSToken ...
 func (tes *tokenExchangeService) ExchangeAccessTokenForIMSToken(accessToken AccessToken, logger *zap.Logger) (*IMSToken, error) {
 	r := tes.newTokenExchangeRequest(logger)
 
-	r.request.Field("grant_type", "urn:ibm:params:oauth:grant-type:derive")
+	r.grantType = "urn:ibm:params:oauth:grant-type:derive"
+	r.request.Field("grant_type", r.grantType)
 	r.request.Field("response_type", "ims_portal")
 	r.request.Field("access_token", accessToken.Token)
 
@@ -109,7 +183,8 @@ func (tes *tokenExchangeService) ExchangeAccessTokenForIMSToken(accessToken Acce
 func (tes *tokenExchangeService) ExchangeIAMAPIKeyForIMSToken(iamAPIKey string, logger *zap.Logger) (*IMSToken, error) {
 	r := tes.newTokenExchangeRequest(logger)
 
-	r.request.Field("grant_type", "urn:ibm:params:oauth:grant-type:apikey")
+	r.grantType = "urn:ibm:params:oauth:grant-type:apikey"
+	r.request.Field("grant_type", r.grantType)
 	r.request.Field("response_type", "ims_portal")
 	r.request.Field("apikey", iamAPIKey)
 
@@ -118,26 +193,279 @@ func (tes *tokenExchangeService) ExchangeIAMAPIKeyForIMSToken(iamAPIKey string,
 
 // ExchangeIAMAPIKeyForAccessToken ...
 func (tes *tokenExchangeService) ExchangeIAMAPIKeyForAccessToken(iamAPIKey string, logger *zap.Logger) (*AccessToken, error) {
+	key := "apikey:" + iamAPIKey
+
+	return tes.cachedAccessToken(key, logger, func() (*AccessToken, error) {
+		r := tes.newTokenExchangeRequest(logger)
+
+		r.grantType = "urn:ibm:params:oauth:grant-type:apikey"
+		r.request.Field("grant_type", r.grantType)
+		r.request.Field("apikey", iamAPIKey)
+
+		return dropClaims(r.exchangeForAccessToken())
+	})
+}
+
+// ExchangeCRTokenForAccessToken ...
+func (tes *tokenExchangeService) ExchangeCRTokenForAccessToken(crTokenPath, profileID string, logger *zap.Logger) (*AccessToken, error) {
+	if crTokenPath == "" {
+		crTokenPath = tes.authConfig.CRTokenPath
+	}
+	if profileID == "" {
+		profileID = tes.authConfig.ProfileID
+	}
+
+	key := "cr-token:" + crTokenPath + ":" + profileID
+
+	return tes.cachedAccessToken(key, logger, func() (*AccessToken, error) {
+		crToken, err := ioutil.ReadFile(crTokenPath)
+		if err != nil {
+			return nil, util.NewError("ErrorUnclassified",
+				"failed to read compute-resource token file", err)
+		}
+
+		r := tes.newTokenExchangeRequest(logger)
+
+		r.grantType = "urn:ibm:params:oauth:grant-type:cr-token"
+		r.request.Field("grant_type", r.grantType)
+		r.request.Field("cr_token", strings.TrimSpace(string(crToken)))
+		if profileID != "" {
+			r.request.Field("profile_id", profileID)
+		} else {
+			r.request.Field("profile_name", tes.authConfig.ProfileName)
+		}
+
+		return dropClaims(r.exchangeForAccessToken())
+	})
+}
+
+// AssumeProfile ...
+func (tes *tokenExchangeService) AssumeProfile(accessToken AccessToken, profileID string, logger *zap.Logger) (*AccessToken, error) {
+	if profileID == "" {
+		profileID = tes.authConfig.ProfileID
+	}
+
+	key := "assume:" + profileID + ":" + accessToken.Token
+
+	return tes.cachedAccessToken(key, logger, func() (*AccessToken, error) {
+		r := tes.newTokenExchangeRequest(logger)
+
+		r.grantType = "urn:ibm:params:oauth:grant-type:assume"
+		r.request.Field("grant_type", r.grantType)
+		r.request.Field("access_token", accessToken.Token)
+		r.request.Field("profile_id", profileID)
+
+		return dropClaims(r.exchangeForAccessToken())
+	})
+}
+
+// ExchangeIAMAPIKeyForScopedAccessToken ...
+func (tes *tokenExchangeService) ExchangeIAMAPIKeyForScopedAccessToken(iamAPIKey string, scope Scope, logger *zap.Logger) (*AccessToken, error) {
+	encodedScope, err := scope.encode()
+	if err != nil {
+		return nil, util.NewError("ErrorUnclassified",
+			"failed to encode token scope", err)
+	}
+
 	r := tes.newTokenExchangeRequest(logger)
 
-	r.request.Field("grant_type", "urn:ibm:params:oauth:grant-type:apikey")
+	r.grantType = "urn:ibm:params:oauth:grant-type:apikey"
+	r.request.Field("grant_type", r.grantType)
 	r.request.Field("apikey", iamAPIKey)
+	r.request.Field("scope", encodedScope)
+
+	token, _, err := r.exchangeForAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := scope.verify(token.Token); err != nil {
+		return nil, util.NewError("ErrorUnclassified",
+			"IAM issued a token whose scope does not match the request", err)
+	}
+
+	return token, nil
+}
+
+// cachedAccessToken returns the access token cached under key if it still
+// has more than tes.skew remaining, transparently exchanging a fresh one
+// via fetch when there is no usable cache entry. Once a cached token enters
+// the skew window it is still returned, but a single-flight background
+// refresh is kicked off so concurrent callers share one IAM round trip
+// instead of each racing to refresh it.
+func (tes *tokenExchangeService) cachedAccessToken(key string, logger *zap.Logger, fetch func() (*AccessToken, error)) (*AccessToken, error) {
+	now := time.Now()
+
+	tes.cacheMu.Lock()
+	entry, ok := tes.cache[key]
+	tes.cacheMu.Unlock()
+
+	if ok && now.Before(entry.expiresAt) {
+		if now.Before(entry.expiresAt.Add(-tes.skew)) {
+			metrics.TokenCacheHitsTotal.Inc()
+			return &entry.token, nil
+		}
+		metrics.TokenCacheHitsTotal.Inc()
+		// Don't start new background work once Close has been called.
+		select {
+		case <-tes.stopCh:
+		default:
+			go tes.refresh(key, "skew_window", logger, fetch)
+		}
+		return &entry.token, nil
+	}
+
+	token, err := tes.refresh(key, "cache_miss", logger, fetch)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// refresh fetches a fresh access token for key, single-flighted so that
+// concurrent callers (foreground misses and the background skew-window
+// refresher) collapse into one IAM request. trigger identifies why the
+// refresh was started, for the iam_token_refresh_total metric.
+func (tes *tokenExchangeService) refresh(key, trigger string, logger *zap.Logger, fetch func() (*AccessToken, error)) (*AccessToken, error) {
+	v, err, _ := tes.sf.Do(key, func() (interface{}, error) {
+		// Counted inside the singleflight closure, which only runs once
+		// per collapsed group of concurrent callers, so iam_token_refresh_total
+		// reflects actual IAM round trips rather than the number of callers
+		// that happened to arrive during one.
+		metrics.TokenRefreshTotal.WithLabelValues(trigger).Inc()
+
+		token, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		tes.storeToken(key, token, logger)
+		return token, nil
+	})
+	if err != nil {
+		if logger != nil {
+			logger.Error("IAM token refresh failed", zap.String("trigger", trigger), zap.String("key", key), zap.Error(err))
+		}
+		return nil, err
+	}
+	return v.(*AccessToken), nil
+}
+
+// storeToken caches token under key, keyed on the expiry parsed out of its
+// JWT `exp` claim. Tokens whose expiry cannot be determined are returned to
+// the caller but are not cached.
+func (tes *tokenExchangeService) storeToken(key string, token *AccessToken, logger *zap.Logger) {
+	expiresAt, err := jwtExpiry(token.Token)
+	if err != nil {
+		if logger != nil {
+			logger.Warn("failed to parse IAM access token expiry, not caching", zap.String("key", key), zap.Error(err))
+		}
+		return
+	}
+
+	tes.cacheMu.Lock()
+	tes.cache[key] = &cachedToken{token: *token, expiresAt: expiresAt}
+	tes.cacheMu.Unlock()
+}
+
+// SetTokenVerifier ...
+func (tes *tokenExchangeService) SetTokenVerifier(verifier TokenVerifier) {
+	tes.verifier = verifier
+}
+
+// SetRefreshToken stores refreshToken as the default ExchangeRefreshTokenForAccessToken
+// falls back to when called with an empty string.
+func (tes *tokenExchangeService) SetRefreshToken(refreshToken string) {
+	tes.refreshTokenMu.Lock()
+	tes.refreshToken = refreshToken
+	tes.refreshTokenMu.Unlock()
+}
+
+// InvalidateCache ...
+func (tes *tokenExchangeService) InvalidateCache(key string) {
+	tes.cacheMu.Lock()
+	delete(tes.cache, key)
+	tes.cacheMu.Unlock()
+}
+
+// Close stops cachedAccessToken from starting any further skew-window
+// background refreshes; a refresh already in flight when Close is called
+// is allowed to finish. Safe to call more than once.
+func (tes *tokenExchangeService) Close() {
+	tes.closeOnce.Do(func() {
+		close(tes.stopCh)
+	})
+}
+
+// decodeJWTPayload unmarshals the (unverified) payload segment of a JWT
+// into v, without checking its signature.
+func decodeJWTPayload(rawJWT string, v interface{}) error {
+	parts := strings.Split(rawJWT, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed JWT: expected header.payload.signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("failed to decode JWT payload: %v", err)
+	}
+
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("failed to unmarshal JWT claims: %v", err)
+	}
+
+	return nil
+}
+
+// jwtExpiry parses the `exp` claim out of a JWT's unverified payload
+// segment, without checking its signature.
+func jwtExpiry(rawJWT string) (time.Time, error) {
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := decodeJWTPayload(rawJWT, &claims); err != nil {
+		return time.Time{}, err
+	}
 
-	return r.exchangeForAccessToken()
+	return time.Unix(claims.Exp, 0), nil
 }
 
-// exchangeForAccessToken ...
-func (r *tokenExchangeRequest) exchangeForAccessToken() (*AccessToken, error) {
+// exchangeForAccessToken ... Additionally verifies the returned token
+// against r.tes.verifier, when one is configured, so the driver never
+// trusts a misissued or tampered IAM token.
+func (r *tokenExchangeRequest) exchangeForAccessToken() (*AccessToken, *Claims, error) {
 	var iamResp *tokenExchangeResponse
 	var err error
 	err = r.errorRetrier.ErrorRetry(func() (error, bool) {
 		iamResp, err = r.sendTokenExchangeRequest()
-		return err, !IsConnectionError(err) // Skip rettry if its not connection error
+		waitForRateLimit(err)
+		// Skip retry unless it's a connection error or IAM asked us to back off
+		return err, !IsConnectionError(err) && !errors.As(err, new(*ErrRateLimited))
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	token := &AccessToken{Token: iamResp.AccessToken}
+
+	if r.tes.verifier == nil {
+		return token, nil, nil
+	}
+
+	claims, err := r.tes.verifier.Verify(context.Background(), iamResp.AccessToken)
+	if err != nil {
+		return nil, nil, util.NewError("ErrorInvalidToken",
+			"IAM-issued access token failed verification", err)
 	}
-	return &AccessToken{Token: iamResp.AccessToken}, nil
+	r.logger.Info("verified IAM access token identity",
+		zap.String("subject", claims.Subject), zap.String("account", claims.Account))
+
+	return token, claims, nil
+}
+
+// dropClaims discards the *Claims result of exchangeForAccessToken for
+// callers that only need the token, preserving the error.
+func dropClaims(token *AccessToken, _ *Claims, err error) (*AccessToken, error) {
+	return token, err
 }
 
 // exchangeForIMSToken ...
@@ -146,7 +474,8 @@ func (r *tokenExchangeRequest) exchangeForIMSToken() (*IMSToken, error) {
 	var err error
 	err = r.errorRetrier.ErrorRetry(func() (error, bool) {
 		iamResp, err = r.sendTokenExchangeRequest()
-		return err, !IsConnectionError(err)
+		waitForRateLimit(err)
+		return err, !IsConnectionError(err) && !errors.As(err, new(*ErrRateLimited))
 	})
 
 	if err != nil {
@@ -178,8 +507,17 @@ func (tes *tokenExchangeService) UpdateAPIKey(apiKey string, logger *zap.Logger)
 	return nil
 }
 
-// sendTokenExchangeRequest ...
-func (r *tokenExchangeRequest) sendTokenExchangeRequest() (*tokenExchangeResponse, error) {
+// sendTokenExchangeRequest ... Every call is counted and timed under
+// iam_token_exchange_requests_total / iam_token_exchange_duration_seconds,
+// labeled by grant type and, for the counter, by a coarse result
+// classification derived from the typed error returned.
+func (r *tokenExchangeRequest) sendTokenExchangeRequest() (result *tokenExchangeResponse, exchangeErr error) {
+	start := time.Now()
+	defer func() {
+		metrics.TokenExchangeDurationSeconds.WithLabelValues(r.grantType).Observe(time.Since(start).Seconds())
+		metrics.TokenExchangeRequestsTotal.WithLabelValues(r.grantType, exchangeResultLabel(exchangeErr)).Inc()
+	}()
+
 	// Set headers
 	basicAuth := fmt.Sprintf("%s:%s", r.tes.authConfig.IamClientID, r.tes.authConfig.IamClientSecret)
 	r.request.Set("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(basicAuth))))
@@ -206,9 +544,7 @@ func (r *tokenExchangeRequest) sendTokenExchangeRequest() (*tokenExchangeRespons
 
 		// TODO Handle timeout here?
 
-		return nil,
-			util.NewError("ErrorUnclassified",
-				"IAM token exchange request failed", err)
+		return nil, &ErrIAMUnavailable{Cause: err}
 	}
 
 	if resp != nil && resp.StatusCode == 200 {
@@ -218,6 +554,17 @@ func (r *tokenExchangeRequest) sendTokenExchangeRequest() (*tokenExchangeRespons
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := parseRetryAfter(resp)
+		r.logger.Error("IAM token exchange request was rate limited",
+			zap.Int("StatusCode", resp.StatusCode), zap.Duration("RetryAfter", retryAfter))
+
+		return nil, &ErrRateLimited{
+			Cause:      errors.New(errorV.ErrorMessage),
+			RetryAfter: retryAfter,
+		}
+	}
+
 	// TODO Check other status code values? (but be careful not to mask the reason codes, below)
 
 	if errorV.ErrorMessage != "" {
@@ -227,39 +574,86 @@ func (r *tokenExchangeRequest) sendTokenExchangeRequest() (*tokenExchangeRespons
 			zap.String("ErrorType:", errorV.ErrorType),
 			zap.Reflect("Error", errorV))
 
-		err := util.NewError("ErrorFailedTokenExchange",
-			"IAM token exchange request failed: "+errorV.ErrorMessage,
-			errors.New(errorV.ErrorDetails+" "+errorV.Requirements.Code+": "+errorV.Requirements.Error))
-
-		if errorV.Requirements.Code == "SoftLayer_Exception_User_Customer_AccountLocked" {
-			err = util.NewError("ErrorProviderAccountTemporarilyLocked",
-				"Infrastructure account is temporarily locked", err)
+		cause := errors.New(errorV.ErrorDetails + " " + errorV.Requirements.Code + ": " + errorV.Requirements.Error)
+
+		switch {
+		case errorV.Requirements.Code == "SoftLayer_Exception_User_Customer_AccountLocked":
+			return nil, &ErrAccountLocked{Cause: cause}
+		case resp.StatusCode == http.StatusUnauthorized:
+			return nil, &ErrTokenExpired{Cause: cause}
+		case errorV.ErrorType == "invalid_grant":
+			return nil, &ErrInvalidAPIKey{Cause: cause}
+		default:
+			return nil, util.NewError("ErrorFailedTokenExchange",
+				"IAM token exchange request failed: "+errorV.ErrorMessage, cause)
 		}
-
-		return nil, err
 	}
 
 	r.logger.Error("Unexpected IAM token exchange response",
 		zap.Int("StatusCode", resp.StatusCode), zap.Reflect("Response", resp))
 
-	return nil,
-		util.NewError("ErrorUnclassified",
-			"Unexpected IAM token exchange response")
+	return nil, &ErrIAMUnavailable{Cause: errors.New("unexpected IAM token exchange response")}
+}
+
+// exchangeResultLabel classifies err for the iam_token_exchange_requests_total
+// metric's result label.
+func exchangeResultLabel(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.As(err, new(*ErrRateLimited)):
+		return "rate_limited"
+	case errors.As(err, new(*ErrAccountLocked)):
+		return "account_locked"
+	case errors.As(err, new(*ErrInvalidAPIKey)):
+		return "invalid_api_key"
+	case errors.As(err, new(*ErrTokenExpired)):
+		return "token_expired"
+	case errors.As(err, new(*ErrIAMUnavailable)):
+		return "iam_unavailable"
+	default:
+		return "error"
+	}
+}
+
+// waitForRateLimit sleeps for the Retry-After duration IAM requested, when
+// err is an ErrRateLimited carrying one, so the next retry attempt honors
+// IAM's requested backoff instead of only the retrier's fixed interval.
+func waitForRateLimit(err error) {
+	var rateLimited *ErrRateLimited
+	if errors.As(err, &rateLimited) && rateLimited.RetryAfter > 0 {
+		time.Sleep(rateLimited.RetryAfter)
+	}
 }
 
-// IsConnectionError ...
+// IsConnectionError reports whether err represents a failure to reach IAM
+// at the network level (as opposed to IAM having responded with an error),
+// based on the concrete type of the underlying cause rather than matching
+// substrings against its message.
 func IsConnectionError(err error) bool {
-	if err != nil {
-		wrappedErrors := util.ErrorDeepUnwrapString(err)
-		// wrapped error contains actual backend error
-		for _, werr := range wrappedErrors {
-			if strings.Contains(werr, "tcp") {
-				// if  error contains "tcp" string, its connection error
-				return true
-			}
-		}
+	if err == nil {
+		return false
+	}
+
+	var unavailable *ErrIAMUnavailable
+	if !errors.As(err, &unavailable) || unavailable.Cause == nil {
+		return false
+	}
+
+	// *net.OpError is the concrete type net/http's transport wraps dial,
+	// read, and write failures in; errors.As unwraps through the *url.Error
+	// http.Client.Do wraps transport errors in to find one if present.
+	// Matching on the broader net.Error interface instead would also match
+	// *url.Error itself (it forwards Timeout()/Temporary() to whatever it
+	// wraps), which http.Client.Do uses for essentially every transport
+	// failure - including non-retryable ones like TLS verification
+	// failures, too-many-redirects, and a canceled context.
+	var opErr *net.OpError
+	if errors.As(unavailable.Cause, &opErr) {
+		return true
 	}
-	return false
+
+	return errors.Is(unavailable.Cause, context.DeadlineExceeded)
 }
 
 // String returns a pointer to the string value provided
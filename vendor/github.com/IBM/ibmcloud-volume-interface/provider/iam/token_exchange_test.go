@@ -0,0 +1,292 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iam
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeJWT builds an unsigned JWT carrying only the `exp` claim needed by
+// jwtExpiry/storeToken. Its signature segment is never verified by these
+// tests since tes.verifier is left nil.
+func fakeJWT(t *testing.T, exp time.Time) string {
+	t.Helper()
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, err := json.Marshal(struct {
+		Exp int64 `json:"exp"`
+	}{Exp: exp.Unix()})
+	if err != nil {
+		t.Fatalf("failed to marshal fake JWT payload: %v", err)
+	}
+
+	return fmt.Sprintf("%s.%s.", header, base64.RawURLEncoding.EncodeToString(payload))
+}
+
+func newTestService(t *testing.T) *tokenExchangeService {
+	t.Helper()
+
+	tes, err := NewTokenExchangeServiceWithClient(&AuthConfiguration{IamURL: "https://iam.test"}, nil)
+	if err != nil {
+		t.Fatalf("NewTokenExchangeServiceWithClient returned error: %v", err)
+	}
+	return tes.(*tokenExchangeService)
+}
+
+func TestExchangeCRTokenForAccessTokenUsesCache(t *testing.T) {
+	tes := newTestService(t)
+
+	// A path that does not exist: if the cache were bypassed, reading it
+	// would fail and the exchange would return an error.
+	const crTokenPath = "/nonexistent/cr-token"
+	const profileID = "profile-1"
+
+	tes.storeToken("cr-token:"+crTokenPath+":"+profileID, &AccessToken{Token: fakeJWT(t, time.Now().Add(time.Hour))}, nil)
+
+	token, err := tes.ExchangeCRTokenForAccessToken(crTokenPath, profileID, nil)
+	if err != nil {
+		t.Fatalf("expected the cached token to be returned without reading %s: %v", crTokenPath, err)
+	}
+	if token.Token == "" {
+		t.Fatalf("expected a non-empty cached token")
+	}
+}
+
+func TestAssumeProfileUsesCache(t *testing.T) {
+	tes := newTestService(t)
+
+	accessToken := AccessToken{Token: "unscoped-token"}
+	const profileID = "profile-1"
+
+	tes.storeToken("assume:"+profileID+":"+accessToken.Token, &AccessToken{Token: fakeJWT(t, time.Now().Add(time.Hour))}, nil)
+
+	token, err := tes.AssumeProfile(accessToken, profileID, nil)
+	if err != nil {
+		t.Fatalf("expected the cached token to be returned without an IAM round trip: %v", err)
+	}
+	if token.Token == "" {
+		t.Fatalf("expected a non-empty cached token")
+	}
+}
+
+func TestExchangeRefreshTokenForAccessTokenRequiresAToken(t *testing.T) {
+	tes := newTestService(t)
+
+	if _, err := tes.ExchangeRefreshTokenForAccessToken("", nil); err == nil {
+		t.Fatalf("expected an error when no refresh token was set or passed explicitly")
+	}
+}
+
+func TestCachedAccessTokenCacheMiss(t *testing.T) {
+	tes := newTestService(t)
+
+	var calls int32
+	fetch := func() (*AccessToken, error) {
+		atomic.AddInt32(&calls, 1)
+		return &AccessToken{Token: fakeJWT(t, time.Now().Add(time.Hour))}, nil
+	}
+
+	token, err := tes.cachedAccessToken("key", nil, fetch)
+	if err != nil {
+		t.Fatalf("cachedAccessToken returned error: %v", err)
+	}
+	if token.Token == "" {
+		t.Fatalf("expected a non-empty token")
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected fetch to be called once, got %d", got)
+	}
+
+	// A second call within the cache lifetime must not fetch again.
+	if _, err := tes.cachedAccessToken("key", nil, fetch); err != nil {
+		t.Fatalf("cachedAccessToken returned error: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected cache hit to skip fetch, fetch called %d times", got)
+	}
+}
+
+func TestCachedAccessTokenSkewWindowRefreshesInBackground(t *testing.T) {
+	tes := newTestService(t)
+
+	var calls int32
+	done := make(chan struct{})
+	fetch := func() (*AccessToken, error) {
+		n := atomic.AddInt32(&calls, 1)
+		token := &AccessToken{Token: fakeJWT(t, time.Now().Add(time.Hour))}
+		if n == 1 {
+			close(done)
+		}
+		return token, nil
+	}
+
+	// Seed the cache with a token that is already inside the skew window.
+	tes.storeToken("key", &AccessToken{Token: fakeJWT(t, time.Now().Add(time.Minute))}, nil)
+
+	token, err := tes.cachedAccessToken("key", nil, fetch)
+	if err != nil {
+		t.Fatalf("cachedAccessToken returned error: %v", err)
+	}
+	if token.Token == "" {
+		t.Fatalf("expected the stale-but-valid cached token to be returned immediately")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected a background refresh to be kicked off")
+	}
+}
+
+func TestCachedAccessTokenSkipsBackgroundRefreshAfterClose(t *testing.T) {
+	tes := newTestService(t)
+	tes.Close()
+
+	var calls int32
+	fetch := func() (*AccessToken, error) {
+		atomic.AddInt32(&calls, 1)
+		return &AccessToken{Token: fakeJWT(t, time.Now().Add(time.Hour))}, nil
+	}
+
+	// Seed the cache with a token that is already inside the skew window.
+	tes.storeToken("key", &AccessToken{Token: fakeJWT(t, time.Now().Add(time.Minute))}, nil)
+
+	if _, err := tes.cachedAccessToken("key", nil, fetch); err != nil {
+		t.Fatalf("cachedAccessToken returned error: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Fatalf("expected Close to prevent a new background refresh, fetch called %d times", got)
+	}
+}
+
+func TestRefreshSingleFlightCollapsesConcurrentCallers(t *testing.T) {
+	tes := newTestService(t)
+
+	var calls int32
+	release := make(chan struct{})
+	fetch := func() (*AccessToken, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &AccessToken{Token: fakeJWT(t, time.Now().Add(time.Hour))}, nil
+	}
+
+	const concurrency = 5
+	results := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			_, err := tes.refresh("key", "cache_miss", nil, fetch)
+			results <- err
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < concurrency; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("refresh returned error: %v", err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected singleflight to collapse concurrent fetches into 1 call, got %d", got)
+	}
+}
+
+func TestIsConnectionErrorMatchesNetOpError(t *testing.T) {
+	err := &ErrIAMUnavailable{Cause: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	if !IsConnectionError(err) {
+		t.Fatalf("expected a *net.OpError cause to be classified as a connection error")
+	}
+}
+
+func TestIsConnectionErrorUnwrapsURLError(t *testing.T) {
+	err := &ErrIAMUnavailable{Cause: &url.Error{
+		Op:  "Post",
+		URL: "https://iam.test/oidc/token",
+		Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+	}}
+	if !IsConnectionError(err) {
+		t.Fatalf("expected a *net.OpError wrapped in *url.Error to be classified as a connection error")
+	}
+}
+
+func TestIsConnectionErrorRejectsNonConnectionURLError(t *testing.T) {
+	// *url.Error implements net.Error directly (it forwards Timeout/
+	// Temporary to whatever it wraps), so a naive net.Error type-match
+	// would misclassify a non-retryable failure like a TLS verification
+	// error as a connection error.
+	err := &ErrIAMUnavailable{Cause: &url.Error{
+		Op:  "Post",
+		URL: "https://iam.test/oidc/token",
+		Err: errors.New("x509: certificate signed by unknown authority"),
+	}}
+	if IsConnectionError(err) {
+		t.Fatalf("expected a non-connection *url.Error cause not to be classified as a connection error")
+	}
+}
+
+func TestIsConnectionErrorMatchesDeadlineExceeded(t *testing.T) {
+	err := &ErrIAMUnavailable{Cause: context.DeadlineExceeded}
+	if !IsConnectionError(err) {
+		t.Fatalf("expected context.DeadlineExceeded to be classified as a connection error")
+	}
+}
+
+func TestIsConnectionErrorNilAndUnrelated(t *testing.T) {
+	if IsConnectionError(nil) {
+		t.Fatalf("expected a nil error not to be a connection error")
+	}
+	if IsConnectionError(&ErrInvalidAPIKey{Cause: errors.New("bad key")}) {
+		t.Fatalf("expected a non-ErrIAMUnavailable error not to be a connection error")
+	}
+}
+
+func TestExchangeResultLabel(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"success", nil, "success"},
+		{"rate limited", &ErrRateLimited{Cause: errors.New("too many requests")}, "rate_limited"},
+		{"account locked", &ErrAccountLocked{Cause: errors.New("locked")}, "account_locked"},
+		{"invalid api key", &ErrInvalidAPIKey{Cause: errors.New("bad key")}, "invalid_api_key"},
+		{"token expired", &ErrTokenExpired{Cause: errors.New("expired")}, "token_expired"},
+		{"iam unavailable", &ErrIAMUnavailable{Cause: errors.New("down")}, "iam_unavailable"},
+		{"unclassified", errors.New("boom"), "error"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := exchangeResultLabel(c.err); got != c.want {
+				t.Fatalf("exchangeResultLabel(%v) = %q, want %q", c.err, got, c.want)
+			}
+		})
+	}
+}
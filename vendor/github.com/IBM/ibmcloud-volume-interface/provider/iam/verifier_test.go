@@ -0,0 +1,169 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iam
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// jwksFixture serves a single RSA key as a JWKS and can sign JWTs under it,
+// standing in for IAM's /identity/keys endpoint and token issuance.
+type jwksFixture struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+func newJWKSFixture(t *testing.T) *jwksFixture {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	return &jwksFixture{key: key, kid: "test-key-1"}
+}
+
+func (f *jwksFixture) server() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		eBytes := big.NewInt(int64(f.key.PublicKey.E)).Bytes()
+		set := struct {
+			Keys []jwk `json:"keys"`
+		}{
+			Keys: []jwk{{
+				Kid: f.kid,
+				Kty: "RSA",
+				N:   base64.RawURLEncoding.EncodeToString(f.key.PublicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(eBytes),
+			}},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+}
+
+func (f *jwksFixture) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	header := map[string]string{"alg": "RS256", "kid": f.kid}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("failed to marshal JWT header: %v", err)
+	}
+	payloadJSON, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed to marshal JWT claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(payloadJSON)
+	hashed := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatalf("failed to sign JWT: %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestTokenVerifierVerify(t *testing.T) {
+	fixture := newJWKSFixture(t)
+	srv := fixture.server()
+	defer srv.Close()
+
+	now := time.Now()
+	token := fixture.sign(t, map[string]interface{}{
+		"sub": "IBMid-test",
+		"iss": "https://iam.cloud.ibm.com/identity",
+		"aud": "test-audience",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+
+	verifier, err := NewTokenVerifier(srv.URL,
+		WithIssuer("https://iam.cloud.ibm.com/identity"),
+		WithAudience("test-audience"))
+	if err != nil {
+		t.Fatalf("NewTokenVerifier returned error: %v", err)
+	}
+	defer verifier.Close()
+
+	claims, err := verifier.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Verify returned error: %v", err)
+	}
+	if claims.Subject != "IBMid-test" {
+		t.Fatalf("unexpected subject: %q", claims.Subject)
+	}
+}
+
+func TestTokenVerifierVerifyRejectsWrongIssuer(t *testing.T) {
+	fixture := newJWKSFixture(t)
+	srv := fixture.server()
+	defer srv.Close()
+
+	now := time.Now()
+	token := fixture.sign(t, map[string]interface{}{
+		"sub": "IBMid-test",
+		"iss": "https://attacker.example/identity",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+
+	verifier, err := NewTokenVerifier(srv.URL, WithIssuer("https://iam.cloud.ibm.com/identity"))
+	if err != nil {
+		t.Fatalf("NewTokenVerifier returned error: %v", err)
+	}
+	defer verifier.Close()
+
+	if _, err := verifier.Verify(context.Background(), token); err == nil {
+		t.Fatalf("expected Verify to reject a token issued by a different issuer")
+	}
+}
+
+func TestTokenVerifierVerifyWithoutWithIssuerSkipsIssuerCheck(t *testing.T) {
+	fixture := newJWKSFixture(t)
+	srv := fixture.server()
+	defer srv.Close()
+
+	now := time.Now()
+	token := fixture.sign(t, map[string]interface{}{
+		"sub": "IBMid-test",
+		"iss": "https://anything.example/identity",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+
+	verifier, err := NewTokenVerifier(srv.URL)
+	if err != nil {
+		t.Fatalf("NewTokenVerifier returned error: %v", err)
+	}
+	defer verifier.Close()
+
+	if _, err := verifier.Verify(context.Background(), token); err != nil {
+		t.Fatalf("expected Verify to skip the issuer check when WithIssuer is not set, got: %v", err)
+	}
+}